@@ -0,0 +1,43 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import "github.com/ethereum/go-ethereum/common"
+
+// Purposes for a delegate sign request, so that the proxied validator knows which
+// key/algorithm to sign the payload with.
+const (
+	// EthstatsDelegateSign marks a request to sign an ethstats login or report message.
+	EthstatsDelegateSign uint8 = iota
+)
+
+// DelegateSignRequestData is the RLP payload of a delegate sign request, sent from a
+// proxy to its proxied validator over the celo_pv subprotocol.
+type DelegateSignRequestData struct {
+	RequestID uint64
+	Purpose   uint8
+	Payload   []byte
+}
+
+// DelegateSignResponseData is the RLP payload of a delegate sign response, sent from a
+// proxied validator back to the proxy that issued the matching request over the
+// celo_pv subprotocol.
+type DelegateSignResponseData struct {
+	RequestID uint64
+	Signature []byte
+	Signer    common.Address
+}
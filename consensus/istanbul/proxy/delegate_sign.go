@@ -0,0 +1,205 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/rlp"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// maxDelegateSignRequestOrigins bounds delegateSignRequestOrigins, so a validator
+// under request pressure (or with proxies that never reply) can't grow the cache
+// without limit.
+const maxDelegateSignRequestOrigins = 256
+
+// pendingDelegateSignRequest holds the channel that a delegate sign caller is blocked
+// on, waiting for the matching DelegateSignResponseMsg to arrive.
+type pendingDelegateSignRequest struct {
+	responseCh chan *istanbul.DelegateSignResponseData
+}
+
+// SendDelegateSignMsgToProxiedValidator asks this proxy's proxied validator to sign
+// msg (e.g. an unsigned ethstats login/report payload) and blocks until either a
+// DelegateSignResponseMsg is received back or delegateSignTimeout elapses.
+func (p *proxyEngine) SendDelegateSignMsgToProxiedValidator(msg []byte) error {
+	logger := p.logger.New("func", "SendDelegateSignMsgToProxiedValidator")
+
+	if p.proxiedValidator == nil {
+		logger.Warn("No proxied validator peer to send the delegate sign request to")
+		return ErrNodeNotProxy
+	}
+
+	requestID := atomic.AddUint64(&p.delegateSignRequestSeq, 1)
+
+	responseCh := make(chan *istanbul.DelegateSignResponseData, 1)
+	p.delegateSignRequestsMu.Lock()
+	p.delegateSignRequests[requestID] = &pendingDelegateSignRequest{responseCh: responseCh}
+	p.delegateSignRequestsMu.Unlock()
+	defer func() {
+		p.delegateSignRequestsMu.Lock()
+		delete(p.delegateSignRequests, requestID)
+		p.delegateSignRequestsMu.Unlock()
+	}()
+
+	reqData := &istanbul.DelegateSignRequestData{
+		RequestID: requestID,
+		Purpose:   istanbul.EthstatsDelegateSign,
+		Payload:   msg,
+	}
+	payload, err := rlp.EncodeToBytes(reqData)
+	if err != nil {
+		logger.Error("Failed to encode delegate sign request", "err", err)
+		return err
+	}
+
+	// Note that, like a ForwardMessage, this isn't signed: it travels over the
+	// trusted internal link between a proxy and its proxied validator.
+	p.unicast(p.proxiedValidator, payload, PVDelegateSignRequestMsg)
+
+	select {
+	case resp := <-responseCh:
+		logger.Debug("Got a delegate sign response", "requestID", requestID, "signer", resp.Signer)
+		return nil
+	case <-time.After(delegateSignTimeout):
+		logger.Warn("Timed out waiting for a delegate sign response", "requestID", requestID)
+		return errDelegateSignTimeout
+	}
+}
+
+// handleDelegateSignRequestMsg is called by the proxied validator when it receives a
+// DelegateSignRequestMsg from one of its proxies. It's not valid for a proxy to
+// receive this message, since only the proxied validator is able to produce the
+// signature, so this lives alongside the proxied validator's handling logic.
+func (pv *proxiedValidatorEngine) handleDelegateSignRequestMsg(peer consensus.Peer, payload []byte) (bool, error) {
+	logger := pv.logger.New("func", "handleDelegateSignRequestMsg")
+
+	// Unlike handleForwardMsg/handleValEnodesShareMsg, which only ever accept a
+	// single proxied validator peer, a proxied validator has many proxies, so this
+	// can't just compare against one expected peer: check that it's one of the
+	// proxies added via AddProxy before signing anything on its behalf.
+	if _, _, err := pv.ph.GetProxy(peer.Node()); err != nil {
+		logger.Warn("Got a delegate sign request from a peer that isn't a configured proxy. Ignoring it", "from", peer.Node().ID())
+		return false, nil
+	}
+
+	var reqData *istanbul.DelegateSignRequestData
+	if err := rlp.DecodeBytes(payload, &reqData); err != nil {
+		logger.Error("Failed to decode a DelegateSignRequestData", "from", peer.Node().ID(), "err", err)
+		return true, err
+	}
+
+	// Remember which proxy asked, so the signed response can be unicast back to it
+	// instead of broadcast to every connected proxy.
+	pv.delegateSignRequestOrigins.Add(reqData.RequestID, peer)
+
+	signature, err := pv.backend.SignDelegatedPayload(reqData.Purpose, reqData.Payload)
+	if err != nil {
+		logger.Error("Failed to sign delegated payload", "requestID", reqData.RequestID, "err", err)
+		return true, err
+	}
+
+	respData := &istanbul.DelegateSignResponseData{
+		RequestID: reqData.RequestID,
+		Signature: signature,
+		Signer:    pv.backend.Address(),
+	}
+	respPayload, err := rlp.EncodeToBytes(respData)
+	if err != nil {
+		logger.Error("Failed to encode delegate sign response", "err", err)
+		return true, err
+	}
+
+	if err := pv.SendDelegateSignMsgToProxy(respPayload); err != nil {
+		logger.Error("Failed to send delegate sign response to proxy", "requestID", reqData.RequestID, "err", err)
+		return true, err
+	}
+
+	return true, nil
+}
+
+// SendDelegateSignMsgToProxy sends an already-assembled DelegateSignResponseMsg
+// payload down to the specific proxy that originated the matching
+// DelegateSignRequestMsg, as tracked in delegateSignRequestOrigins. It returns
+// ErrNoStatsProxy if that proxy is no longer connected, or never asked.
+func (pv *proxiedValidatorEngine) SendDelegateSignMsgToProxy(msg []byte) error {
+	logger := pv.logger.New("func", "SendDelegateSignMsgToProxy")
+
+	var respData *istanbul.DelegateSignResponseData
+	if err := rlp.DecodeBytes(msg, &respData); err != nil {
+		logger.Error("Failed to decode a DelegateSignResponseData", "err", err)
+		return err
+	}
+
+	originPeer, ok := pv.delegateSignRequestOrigins.Get(respData.RequestID)
+	if !ok {
+		logger.Warn("No live proxy context for this delegate sign request", "requestID", respData.RequestID)
+		return ErrNoStatsProxy
+	}
+	pv.delegateSignRequestOrigins.Remove(respData.RequestID)
+
+	pv.unicast(originPeer.(consensus.Peer), msg, PVDelegateSignResponseMsg)
+
+	return nil
+}
+
+// evictDelegateSignRequestsFromPeer removes any outstanding delegate sign request
+// origins pointing at proxyPeer. It's called from UnregisterProxyPeer so that a
+// disconnected proxy's entries don't linger in delegateSignRequestOrigins until they
+// age out of the LRU on their own.
+func (pv *proxiedValidatorEngine) evictDelegateSignRequestsFromPeer(proxyPeer consensus.Peer) {
+	for _, key := range pv.delegateSignRequestOrigins.Keys() {
+		if origin, ok := pv.delegateSignRequestOrigins.Peek(key); ok && origin.(consensus.Peer).Node().ID() == proxyPeer.Node().ID() {
+			pv.delegateSignRequestOrigins.Remove(key)
+		}
+	}
+}
+
+// handleDelegateSignResponseMsg is called by a proxy when it receives a
+// DelegateSignResponseMsg from its proxied validator, and wakes up whichever caller of
+// SendDelegateSignMsgToProxiedValidator is blocked on this RequestID, if any.
+func (p *proxyEngine) handleDelegateSignResponseMsg(peer consensus.Peer, payload []byte) (bool, error) {
+	logger := p.logger.New("func", "handleDelegateSignResponseMsg")
+
+	if p.proxiedValidator == nil || p.proxiedValidator.Node().ID() != peer.Node().ID() {
+		logger.Warn("Got a delegate sign response from a peer that is not the proxy's proxied validator. Ignoring it", "from", peer.Node().ID())
+		return false, nil
+	}
+
+	var respData *istanbul.DelegateSignResponseData
+	if err := rlp.DecodeBytes(payload, &respData); err != nil {
+		logger.Error("Failed to decode a DelegateSignResponseData", "from", peer.Node().ID(), "err", err)
+		return true, err
+	}
+
+	p.delegateSignRequestsMu.Lock()
+	pending, ok := p.delegateSignRequests[respData.RequestID]
+	p.delegateSignRequestsMu.Unlock()
+
+	if !ok {
+		logger.Warn("Got a delegate sign response for an unknown or expired request", "requestID", respData.RequestID)
+		return true, ErrNoStatsProxy
+	}
+
+	pending.responseCh <- respData
+
+	return true, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import "github.com/ethereum/go-ethereum/consensus/istanbul"
+
+// SendEnodeCertificateMsgToProxiedValidator forwards this proxy's own signed enode
+// certificate message up to its proxied validator, so the validator knows how to
+// reach the proxy's external enode. It's sent over celo_pv so it's subject to the
+// same fault-injection and Hello-handshake gating as the rest of the proxy<->proxied
+// validator link traffic.
+func (p *proxyEngine) SendEnodeCertificateMsgToProxiedValidator(msg *istanbul.Message) error {
+	logger := p.logger.New("func", "SendEnodeCertificateMsgToProxiedValidator")
+
+	if p.proxiedValidator == nil {
+		logger.Warn("No proxied validator peer to send the enode certificate to")
+		return ErrNodeNotProxy
+	}
+
+	payload, err := msg.Payload()
+	if err != nil {
+		logger.Error("Failed to get payload for the enode certificate message", "err", err)
+		return err
+	}
+
+	p.unicast(p.proxiedValidator, payload, PVShareEnodeCertificateMsg)
+
+	return nil
+}
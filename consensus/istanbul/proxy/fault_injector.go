@@ -0,0 +1,314 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// DelayModel selects how FaultInjector samples injected latency for a direction.
+type DelayModel int
+
+const (
+	// NoDelay disables injected latency for a direction.
+	NoDelay DelayModel = iota
+	// UniformDelay samples uniformly from [Delay-Variance, Delay+Variance].
+	UniformDelay
+	// ParetoDelay samples a heavy-tailed delay with Delay as its scale, mimicking
+	// the occasional very slow frame seen on real networks under load.
+	ParetoDelay
+)
+
+// DirectionConfig holds the fault-injection knobs for one direction of traffic on the
+// proxy<->proxied validator link.
+type DirectionConfig struct {
+	BlackholePercent int           // 0-100, percent of frames silently dropped
+	DelayModel       DelayModel    // how Delay/DelayVariance are interpreted
+	Delay            time.Duration // base delay, or Pareto scale
+	DelayVariance    time.Duration // uniform half-width; not used by ParetoDelay
+	ReorderPercent   int           // 0-100, percent of frames given a random extra delay so they may overtake or be overtaken by others
+	DuplicatePercent int           // 0-100, percent of frames also delivered a second time
+	BandwidthCapBps  int64         // 0 means unlimited
+}
+
+// ServerConfig configures a FaultInjector's behavior in each direction independently,
+// modeled on the per-direction knobs of network fault-injection proxies (e.g.
+// toxiproxy) used to reproduce distributed-systems bugs deterministically in tests.
+type ServerConfig struct {
+	Tx DirectionConfig // frames sent by the wrapped sender
+	Rx DirectionConfig // frames delivered to the wrapped receiver
+}
+
+// sender is the subset of the proxy<->proxied validator transport that FaultInjector
+// wraps: SendForwardMsg, SendEnodeCertificateMsgToProxiedValidator and
+// sendValEnodesShareMsg all eventually call Unicast to hand a payload to a peer.
+type sender interface {
+	Unicast(peer consensus.Peer, payload []byte, ethMsgCode uint64)
+}
+
+// FaultInjector wraps a sender and applies configurable blackholing, latency,
+// reordering, duplication and bandwidth caps to it, so integration tests can
+// reproduce reported issues (e.g. "proxy peer stops sending EnodeCertificateMsg after
+// a network hiccup") deterministically instead of relying on a flaky real network.
+type FaultInjector struct {
+	next   sender
+	logger log.Logger
+
+	mu         sync.RWMutex
+	cfg        ServerConfig
+	blackholed bool
+
+	txBytes, rxBytes uint64
+	dropped, delayed uint64
+}
+
+// NewFaultInjector returns a FaultInjector that forwards to next once it has applied
+// the configured faults.
+func NewFaultInjector(next sender) *FaultInjector {
+	return &FaultInjector{
+		next:   next,
+		logger: log.New("module", "proxy/faultinjector"),
+	}
+}
+
+// SetConfig atomically replaces the injector's per-direction configuration.
+func (f *FaultInjector) SetConfig(cfg ServerConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cfg = cfg
+}
+
+// Config returns the injector's current per-direction configuration.
+func (f *FaultInjector) Config() ServerConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cfg
+}
+
+// Blackhole drops every frame in both directions until Unblackhole is called,
+// regardless of the configured BlackholePercent.
+func (f *FaultInjector) Blackhole() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blackholed = true
+}
+
+// Unblackhole undoes a prior call to Blackhole.
+func (f *FaultInjector) Unblackhole() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blackholed = false
+}
+
+// DelayTx sets a fixed uniform delay, with the given variance, on the Tx direction.
+func (f *FaultInjector) DelayTx(delay, variance time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cfg.Tx.DelayModel = UniformDelay
+	f.cfg.Tx.Delay = delay
+	f.cfg.Tx.DelayVariance = variance
+}
+
+// Stats is a point-in-time snapshot of FaultInjector's counters, suitable for
+// returning from an RPC call.
+type Stats struct {
+	TxBytes uint64 `json:"txBytes"`
+	RxBytes uint64 `json:"rxBytes"`
+	Dropped uint64 `json:"dropped"`
+	Delayed uint64 `json:"delayed"`
+}
+
+// Stats returns a snapshot of the injector's TxBytes/RxBytes/Dropped/Delayed counters.
+func (f *FaultInjector) Stats() Stats {
+	return Stats{
+		TxBytes: atomic.LoadUint64(&f.txBytes),
+		RxBytes: atomic.LoadUint64(&f.rxBytes),
+		Dropped: atomic.LoadUint64(&f.dropped),
+		Delayed: atomic.LoadUint64(&f.delayed),
+	}
+}
+
+// Unicast applies the injector's Tx direction faults and, unless the frame is
+// dropped, eventually calls through to the wrapped sender's Unicast.
+func (f *FaultInjector) Unicast(peer consensus.Peer, payload []byte, ethMsgCode uint64) {
+	f.mu.RLock()
+	cfg := f.cfg.Tx
+	blackholed := f.blackholed
+	f.mu.RUnlock()
+
+	atomic.AddUint64(&f.txBytes, uint64(len(payload)))
+
+	if blackholed || rollPercent(cfg.BlackholePercent) {
+		atomic.AddUint64(&f.dropped, 1)
+		f.logger.Trace("Dropping frame", "ethMsgCode", ethMsgCode, "to", peer.Node().ID())
+		return
+	}
+
+	deliver := func() {
+		f.next.Unicast(peer, payload, ethMsgCode)
+		if rollPercent(cfg.DuplicatePercent) {
+			f.next.Unicast(peer, payload, ethMsgCode)
+		}
+	}
+
+	if d := sampleDelay(cfg) + bandwidthDelay(cfg, len(payload)); d > 0 {
+		atomic.AddUint64(&f.delayed, 1)
+		time.AfterFunc(d, deliver)
+		return
+	}
+
+	deliver()
+}
+
+// ApplyRx applies the injector's Rx direction faults to an inbound frame of the given
+// length. Unlike Unicast, it can't hand delivery off to a "next" receiver on the
+// caller's behalf - HandlePVMsg dispatches synchronously - so it blocks for the sampled
+// delay itself and reports whether the frame should still be dispatched; false means it
+// was blackholed and the caller must drop it.
+func (f *FaultInjector) ApplyRx(payloadLen int) bool {
+	f.mu.RLock()
+	cfg := f.cfg.Rx
+	blackholed := f.blackholed
+	f.mu.RUnlock()
+
+	atomic.AddUint64(&f.rxBytes, uint64(payloadLen))
+
+	if blackholed || rollPercent(cfg.BlackholePercent) {
+		atomic.AddUint64(&f.dropped, 1)
+		f.logger.Trace("Dropping inbound frame", "len", payloadLen)
+		return false
+	}
+
+	if d := sampleDelay(cfg) + bandwidthDelay(cfg, payloadLen); d > 0 {
+		atomic.AddUint64(&f.delayed, 1)
+		time.Sleep(d)
+	}
+
+	return true
+}
+
+// bandwidthDelay returns how long a frame of payloadLen bytes must be held back to keep
+// cfg's direction under BandwidthCapBps. A cap of 0 means unlimited, so no delay.
+func bandwidthDelay(cfg DirectionConfig, payloadLen int) time.Duration {
+	if cfg.BandwidthCapBps <= 0 {
+		return 0
+	}
+	return time.Duration(int64(payloadLen) * 8 * int64(time.Second) / cfg.BandwidthCapBps)
+}
+
+// rollPercent returns true with the given percent (0-100) probability.
+func rollPercent(percent int) bool {
+	if percent <= 0 {
+		return false
+	}
+	return rand.Intn(100) < percent
+}
+
+// sampleDelay draws a delay for cfg, additionally applying its ReorderPercent by
+// occasionally drawing a second, independent delay on top so a frame may arrive out
+// of order relative to its neighbors.
+func sampleDelay(cfg DirectionConfig) time.Duration {
+	d := sampleDirectionDelay(cfg)
+	if rollPercent(cfg.ReorderPercent) {
+		d += sampleDirectionDelay(cfg)
+	}
+	return d
+}
+
+// unicast sends payload to peer via p.faultInjector when fault injection is enabled on
+// this proxy, so the configured blackhole/delay/duplicate faults apply to every
+// outgoing celo_pv message regardless of which Send* call produced it; otherwise it
+// falls straight through to the real backend.
+func (p *proxyEngine) unicast(peer consensus.Peer, payload []byte, ethMsgCode uint64) {
+	if p.faultInjector != nil {
+		p.faultInjector.Unicast(peer, payload, ethMsgCode)
+		return
+	}
+	p.backend.Unicast(peer, payload, ethMsgCode)
+}
+
+// unicast is the proxied validator side counterpart of proxyEngine.unicast.
+func (pv *proxiedValidatorEngine) unicast(peer consensus.Peer, payload []byte, ethMsgCode uint64) {
+	if pv.faultInjector != nil {
+		pv.faultInjector.Unicast(peer, payload, ethMsgCode)
+		return
+	}
+	pv.backend.Unicast(peer, payload, ethMsgCode)
+}
+
+// APIs returns the RPC APIs this proxy exposes. faultInjector is nil, and so
+// PrivateFaultInjectorAPI is a no-op, unless fault injection was explicitly enabled on
+// this node at startup.
+func (p *proxyEngine) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "debug",
+			Version:   "1.0",
+			Service:   NewPrivateFaultInjectorAPI(p.faultInjector),
+			Public:    false,
+		},
+	}
+}
+
+// APIs is the proxied validator side counterpart of proxyEngine.APIs.
+func (pv *proxiedValidatorEngine) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "debug",
+			Version:   "1.0",
+			Service:   NewPrivateFaultInjectorAPI(pv.faultInjector),
+			Public:    false,
+		},
+	}
+}
+
+func sampleDirectionDelay(cfg DirectionConfig) time.Duration {
+	switch cfg.DelayModel {
+	case UniformDelay:
+		if cfg.DelayVariance <= 0 {
+			return cfg.Delay
+		}
+		offset := time.Duration(rand.Int63n(2*int64(cfg.DelayVariance))) - cfg.DelayVariance
+		d := cfg.Delay + offset
+		if d < 0 {
+			return 0
+		}
+		return d
+	case ParetoDelay:
+		if cfg.Delay <= 0 {
+			return 0
+		}
+		// Classic Pareto sample: scale / U^(1/shape), with a shape of 1.5 chosen to
+		// give an occasional long tail without dominating the average case.
+		const shape = 1.5
+		u := rand.Float64()
+		if u <= 0 {
+			u = 1e-9
+		}
+		return time.Duration(float64(cfg.Delay) / math.Pow(u, 1/shape))
+	default:
+		return 0
+	}
+}
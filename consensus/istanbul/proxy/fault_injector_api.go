@@ -0,0 +1,86 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import "time"
+
+// PrivateFaultInjectorAPI exposes a FaultInjector under the debug_ RPC namespace, so
+// an operator or an integration test harness can toggle fault injection on the
+// proxy<->proxied validator link at runtime without restarting the node.
+type PrivateFaultInjectorAPI struct {
+	fi *FaultInjector
+}
+
+// NewPrivateFaultInjectorAPI returns an API backed by fi. It's a no-op API (every
+// call returns an error) if fi is nil, e.g. when fault injection wasn't enabled at
+// startup.
+func NewPrivateFaultInjectorAPI(fi *FaultInjector) *PrivateFaultInjectorAPI {
+	return &PrivateFaultInjectorAPI{fi: fi}
+}
+
+// FaultInjectorStats returns the injector's TxBytes/RxBytes/Dropped/Delayed counters.
+func (api *PrivateFaultInjectorAPI) FaultInjectorStats() (Stats, error) {
+	if api.fi == nil {
+		return Stats{}, errFaultInjectorNotEnabled
+	}
+	return api.fi.Stats(), nil
+}
+
+// FaultInjectorConfig returns the injector's current per-direction configuration.
+func (api *PrivateFaultInjectorAPI) FaultInjectorConfig() (ServerConfig, error) {
+	if api.fi == nil {
+		return ServerConfig{}, errFaultInjectorNotEnabled
+	}
+	return api.fi.Config(), nil
+}
+
+// SetFaultInjectorConfig replaces the injector's per-direction configuration.
+func (api *PrivateFaultInjectorAPI) SetFaultInjectorConfig(cfg ServerConfig) error {
+	if api.fi == nil {
+		return errFaultInjectorNotEnabled
+	}
+	api.fi.SetConfig(cfg)
+	return nil
+}
+
+// Blackhole drops every frame on the link until Unblackhole is called.
+func (api *PrivateFaultInjectorAPI) Blackhole() error {
+	if api.fi == nil {
+		return errFaultInjectorNotEnabled
+	}
+	api.fi.Blackhole()
+	return nil
+}
+
+// Unblackhole undoes a prior call to Blackhole.
+func (api *PrivateFaultInjectorAPI) Unblackhole() error {
+	if api.fi == nil {
+		return errFaultInjectorNotEnabled
+	}
+	api.fi.Unblackhole()
+	return nil
+}
+
+// DelayTx sets a fixed uniform delay, with the given variance, on outgoing frames.
+// Durations are given in milliseconds to keep the RPC call ergonomic.
+func (api *PrivateFaultInjectorAPI) DelayTx(delayMs, varianceMs int64) error {
+	if api.fi == nil {
+		return errFaultInjectorNotEnabled
+	}
+	api.fi.DelayTx(time.Duration(delayMs)*time.Millisecond, time.Duration(varianceMs)*time.Millisecond)
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// newTestPeer returns a consensus.Peer backed by a throwaway node, so tests that drop
+// a frame can exercise FaultInjector.Unicast's logging of peer.Node().ID() without a
+// real p2p connection.
+func newTestPeer(t *testing.T) consensus.Peer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+	return fakePeer{node: enode.NewV4(&key.PublicKey, net.IPv4(127, 0, 0, 1), 30303, 30303)}
+}
+
+type fakePeer struct {
+	consensus.Peer
+	node *enode.Node
+}
+
+func (f fakePeer) Node() *enode.Node { return f.node }
+
+// fakeSender records every call made to it, so tests can assert on what a FaultInjector
+// actually forwards instead of only on the delay/drop sampling helpers in isolation.
+type fakeSender struct {
+	mu    sync.Mutex
+	calls []fakeSenderCall
+}
+
+type fakeSenderCall struct {
+	peer    consensus.Peer
+	payload []byte
+	code    uint64
+}
+
+func (s *fakeSender) Unicast(peer consensus.Peer, payload []byte, ethMsgCode uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, fakeSenderCall{peer, payload, ethMsgCode})
+}
+
+func (s *fakeSender) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.calls)
+}
+
+func TestFaultInjectorUnicastForwardsToSender(t *testing.T) {
+	next := &fakeSender{}
+	f := NewFaultInjector(next)
+
+	peer := newTestPeer(t)
+	payload := []byte("hello")
+	f.Unicast(peer, payload, PVForwardMsg)
+
+	if got := next.callCount(); got != 1 {
+		t.Fatalf("got %d calls to the wrapped sender, want 1", got)
+	}
+	if stats := f.Stats(); stats.TxBytes != uint64(len(payload)) {
+		t.Errorf("TxBytes: got %d, want %d", stats.TxBytes, len(payload))
+	}
+}
+
+func TestFaultInjectorUnicastBlackholeDropsFrame(t *testing.T) {
+	next := &fakeSender{}
+	f := NewFaultInjector(next)
+	f.SetConfig(ServerConfig{Tx: DirectionConfig{BlackholePercent: 100}})
+
+	f.Unicast(newTestPeer(t), []byte("hello"), PVForwardMsg)
+
+	if got := next.callCount(); got != 0 {
+		t.Fatalf("got %d calls to the wrapped sender, want 0 - frame should have been dropped", got)
+	}
+	if stats := f.Stats(); stats.Dropped != 1 {
+		t.Errorf("Dropped: got %d, want 1", stats.Dropped)
+	}
+}
+
+func TestFaultInjectorBlackholeOverridesConfig(t *testing.T) {
+	next := &fakeSender{}
+	f := NewFaultInjector(next)
+
+	f.Blackhole()
+	f.Unicast(newTestPeer(t), []byte("hello"), PVForwardMsg)
+	if got := next.callCount(); got != 0 {
+		t.Fatalf("got %d calls while blackholed, want 0", got)
+	}
+
+	f.Unblackhole()
+	f.Unicast(newTestPeer(t), []byte("hello"), PVForwardMsg)
+	if got := next.callCount(); got != 1 {
+		t.Fatalf("got %d calls after Unblackhole, want 1", got)
+	}
+}
+
+func TestFaultInjectorUnicastDuplicatePercentSendsTwice(t *testing.T) {
+	next := &fakeSender{}
+	f := NewFaultInjector(next)
+	f.SetConfig(ServerConfig{Tx: DirectionConfig{DuplicatePercent: 100}})
+
+	f.Unicast(newTestPeer(t), []byte("hello"), PVForwardMsg)
+
+	if got := next.callCount(); got != 2 {
+		t.Fatalf("got %d calls to the wrapped sender, want 2 duplicate deliveries", got)
+	}
+}
+
+func TestApplyRxBlackholeDropsAndCountsBytes(t *testing.T) {
+	f := NewFaultInjector(&fakeSender{})
+	f.SetConfig(ServerConfig{Rx: DirectionConfig{BlackholePercent: 100}})
+
+	if allow := f.ApplyRx(10); allow {
+		t.Fatal("expected ApplyRx to report the frame as dropped")
+	}
+	if stats := f.Stats(); stats.RxBytes != 10 || stats.Dropped != 1 {
+		t.Errorf("got RxBytes=%d Dropped=%d, want RxBytes=10 Dropped=1", stats.RxBytes, stats.Dropped)
+	}
+}
+
+func TestApplyRxAllowsByDefault(t *testing.T) {
+	f := NewFaultInjector(&fakeSender{})
+
+	if allow := f.ApplyRx(10); !allow {
+		t.Fatal("expected ApplyRx to allow the frame through with no faults configured")
+	}
+}
+
+func TestBandwidthDelayScalesWithPayloadSize(t *testing.T) {
+	cfg := DirectionConfig{BandwidthCapBps: 8000} // 1000 bytes/sec
+	if d := bandwidthDelay(cfg, 1000); d != time.Second {
+		t.Errorf("got %v, want 1s for a 1000 byte frame at 8000bps", d)
+	}
+	if d := bandwidthDelay(cfg, 0); d != 0 {
+		t.Errorf("got %v, want 0 for an empty frame", d)
+	}
+}
+
+func TestBandwidthDelayUnlimitedByDefault(t *testing.T) {
+	if d := bandwidthDelay(DirectionConfig{}, 1<<20); d != 0 {
+		t.Errorf("got %v, want 0 with no BandwidthCapBps configured", d)
+	}
+}
+
+func TestRollPercent(t *testing.T) {
+	if rollPercent(0) {
+		t.Error("0 percent should never roll true")
+	}
+	if rollPercent(-5) {
+		t.Error("a negative percent should never roll true")
+	}
+	if !rollPercent(100) {
+		t.Error("100 percent should always roll true")
+	}
+}
+
+func TestSampleDirectionDelayNoDelay(t *testing.T) {
+	cfg := DirectionConfig{DelayModel: NoDelay, Delay: time.Second}
+	if d := sampleDirectionDelay(cfg); d != 0 {
+		t.Errorf("NoDelay model: got %v, want 0", d)
+	}
+}
+
+func TestSampleDirectionDelayUniform(t *testing.T) {
+	cfg := DirectionConfig{
+		DelayModel:    UniformDelay,
+		Delay:         100 * time.Millisecond,
+		DelayVariance: 20 * time.Millisecond,
+	}
+
+	for i := 0; i < 100; i++ {
+		d := sampleDirectionDelay(cfg)
+		if d < 80*time.Millisecond || d > 120*time.Millisecond {
+			t.Fatalf("uniform sample %v outside [80ms, 120ms]", d)
+		}
+	}
+}
+
+func TestSampleDirectionDelayUniformNoVariance(t *testing.T) {
+	cfg := DirectionConfig{DelayModel: UniformDelay, Delay: 50 * time.Millisecond}
+	if d := sampleDirectionDelay(cfg); d != 50*time.Millisecond {
+		t.Errorf("zero-variance uniform sample: got %v, want exactly 50ms", d)
+	}
+}
+
+func TestSampleDirectionDelayPareto(t *testing.T) {
+	cfg := DirectionConfig{DelayModel: ParetoDelay, Delay: 10 * time.Millisecond}
+
+	for i := 0; i < 100; i++ {
+		if d := sampleDirectionDelay(cfg); d < 0 {
+			t.Fatalf("pareto sample must never be negative, got %v", d)
+		}
+	}
+}
+
+func TestSampleDirectionDelayParetoZeroDelay(t *testing.T) {
+	cfg := DirectionConfig{DelayModel: ParetoDelay, Delay: 0}
+	if d := sampleDirectionDelay(cfg); d != 0 {
+		t.Errorf("zero-scale pareto sample: got %v, want 0", d)
+	}
+}
+
+func TestSampleDelayReorderOnlyAddsDelay(t *testing.T) {
+	cfg := DirectionConfig{
+		DelayModel:     UniformDelay,
+		Delay:          10 * time.Millisecond,
+		ReorderPercent: 100,
+	}
+
+	// With ReorderPercent at 100, sampleDelay should always add a second,
+	// independent draw on top of the base delay.
+	for i := 0; i < 20; i++ {
+		if d := sampleDelay(cfg); d < 10*time.Millisecond {
+			t.Fatalf("reordered sample %v should be at least the base delay", d)
+		}
+	}
+}
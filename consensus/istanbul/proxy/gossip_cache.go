@@ -0,0 +1,83 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// maxGossipCacheEntries bounds gossipCache, one entry per distinct payload recently
+// forwarded, regardless of how many proxies it was sent to.
+const maxGossipCacheEntries = 1024
+
+// gossipCache tracks, for each recently forwarded payload (keyed by its keccak256
+// hash), which proxies it has already been sent to. This lets SendForwardMsg skip
+// proxies that already received the exact same wrapped payload in an earlier round,
+// instead of re-forwarding it to every connected proxy every time.
+type gossipCache struct {
+	mu    sync.Mutex
+	cache *lru.Cache // common.Hash -> map[enode.ID]bool
+}
+
+func newGossipCache() *gossipCache {
+	cache, _ := lru.New(maxGossipCacheEntries)
+	return &gossipCache{cache: cache}
+}
+
+// seen reports whether payload has already been sent to peerID, and if not, marks it
+// as sent so a later call for the same (payload, peerID) pair returns true.
+func (g *gossipCache) seen(payload []byte, peerID enode.ID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := common.BytesToHash(crypto.Keccak256(payload))
+
+	var sentTo map[enode.ID]bool
+	if v, ok := g.cache.Get(key); ok {
+		sentTo = v.(map[enode.ID]bool)
+	} else {
+		sentTo = make(map[enode.ID]bool)
+		g.cache.Add(key, sentTo)
+	}
+
+	if sentTo[peerID] {
+		return true
+	}
+	sentTo[peerID] = true
+	return false
+}
+
+// evictPeer removes peerID's "already sent" marker from every payload currently
+// tracked, so a proxy that disconnects and later reconnects is resent anything it may
+// never have actually received, instead of being silently skipped forever.
+func (g *gossipCache) evictPeer(peerID enode.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, key := range g.cache.Keys() {
+		v, ok := g.cache.Peek(key)
+		if !ok {
+			continue
+		}
+		delete(v.(map[enode.ID]bool), peerID)
+	}
+}
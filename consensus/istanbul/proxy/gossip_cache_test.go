@@ -0,0 +1,94 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestGossipCacheSendsOncePerProxy(t *testing.T) {
+	gc := newGossipCache()
+
+	payload := []byte("some forward message payload")
+	const numProxies = 5
+	const numRounds = 3
+
+	proxies := make([]enode.ID, numProxies)
+	for i := range proxies {
+		proxies[i][0] = byte(i + 1)
+	}
+
+	sentCount := make(map[enode.ID]int)
+	for round := 0; round < numRounds; round++ {
+		for _, proxyID := range proxies {
+			if !gc.seen(payload, proxyID) {
+				sentCount[proxyID]++
+			}
+		}
+	}
+
+	for _, proxyID := range proxies {
+		if got := sentCount[proxyID]; got != 1 {
+			t.Errorf("proxy %v: got %d sends across %d rounds, want 1", proxyID, got, numRounds)
+		}
+	}
+}
+
+func TestGossipCacheDistinctPayloadsAreIndependent(t *testing.T) {
+	gc := newGossipCache()
+
+	var proxyID enode.ID
+	proxyID[0] = 1
+
+	if gc.seen([]byte("payload A"), proxyID) {
+		t.Fatal("expected the first sighting of payload A to be unseen")
+	}
+	if gc.seen([]byte("payload A"), proxyID) == false {
+		t.Fatal("expected the second sighting of payload A to be seen")
+	}
+	if gc.seen([]byte("payload B"), proxyID) {
+		t.Fatal("a different payload to the same proxy should not be marked seen")
+	}
+}
+
+func TestGossipCacheEvictPeerForgetsWhatWasSent(t *testing.T) {
+	gc := newGossipCache()
+
+	var proxyA, proxyB enode.ID
+	proxyA[0] = 1
+	proxyB[0] = 2
+
+	payload := []byte("some forward message payload")
+
+	if gc.seen(payload, proxyA) {
+		t.Fatal("expected the first sighting for proxyA to be unseen")
+	}
+	if gc.seen(payload, proxyB) {
+		t.Fatal("expected the first sighting for proxyB to be unseen")
+	}
+
+	gc.evictPeer(proxyA)
+
+	if gc.seen(payload, proxyA) {
+		t.Error("expected proxyA's entry to be forgotten after evictPeer, so it can be resent")
+	}
+	if !gc.seen(payload, proxyB) {
+		t.Error("evicting proxyA should not affect proxyB's already-seen state")
+	}
+}
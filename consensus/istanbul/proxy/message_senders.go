@@ -0,0 +1,214 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+// This file holds the proxied validator's concrete Send* implementations for the
+// celo_pv subprotocol: SendForwardMsg, sendValEnodesShareMsg and
+// sendShareEnodeCertificateMsg, the send-side counterpart of the proxy's
+// handleShareEnodeCertificateMsg. protocol.go owns subprotocol registration and
+// message-code dispatch; gossip_cache.go owns the duplicate-suppression state these
+// senders consult.
+
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// valEnodeSeqnoCounters hands out a monotonically increasing Seqno per proxy peer, so
+// each proxy's seqnoTracker can tell a resend of the same val enode table apart from a
+// replay of a stale one, independently of traffic sent to any other proxy.
+type valEnodeSeqnoCounters struct {
+	mu     sync.Mutex
+	seqnos map[enode.ID]uint64
+}
+
+func newValEnodeSeqnoCounters() *valEnodeSeqnoCounters {
+	return &valEnodeSeqnoCounters{seqnos: make(map[enode.ID]uint64)}
+}
+
+// next returns the next Seqno to use for peerID, starting at 1.
+func (c *valEnodeSeqnoCounters) next(peerID enode.ID) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seqnos[peerID]++
+	return c.seqnos[peerID]
+}
+
+// SendForwardMsg sends payload, wrapped in an istanbul.ForwardMessage, to the proxies
+// assigned to validatorAddresses (or to proxyPeers directly, if given). Proxies that
+// gossipCache has already seen this exact payload for are skipped.
+func (pv *proxiedValidatorEngine) SendForwardMsg(proxyPeers []consensus.Peer, validatorAddresses []common.Address, ethMsgCode uint64, payload []byte, proxySpecificPayloads map[enode.ID][]byte) error {
+	logger := pv.logger.New("func", "SendForwardMsg")
+
+	logger.Info("Sending forward msg", "ethMsgCode", ethMsgCode, "validatorAddresses", common.ConvertToStringSlice(validatorAddresses))
+
+	proxyToAddressesMap := make(map[consensus.Peer][]common.Address)
+
+	// If the proxy peers are not given to this function, then retrieve them via the proxy handler
+	if proxyPeers == nil {
+		valAssignments, err := pv.ph.GetValidatorAssignments(validatorAddresses)
+		if err != nil {
+			logger.Warn("Got an error when trying to retrieve validator assignments", "err", err)
+			return err
+		}
+
+		// Create proxy -> set of validator addresses map
+		for valAddress, proxy := range valAssignments {
+			if proxy != nil && proxy.peer != nil {
+				if proxyToAddressesMap[proxy.peer] == nil {
+					proxyToAddressesMap[proxy.peer] = make([]common.Address, 0)
+				}
+
+				proxyToAddressesMap[proxy.peer] = append(proxyToAddressesMap[proxy.peer], valAddress)
+			}
+		}
+
+		if len(proxyToAddressesMap) == 0 {
+			logger.Warn("No proxy assigned to any of the final dest addresses for sending a fwd message", "ethMsgCode", ethMsgCode, "finalDestAddreses", common.ConvertToStringSlice(validatorAddresses))
+			return nil
+		}
+	} else {
+		for _, proxyPeer := range proxyPeers {
+			proxyToAddressesMap[proxyPeer] = nil
+		}
+	}
+
+	// Send the forward messages to the proxies
+	for proxyPeer, valAddresses := range proxyToAddressesMap {
+		// Convert the message to a fwdMessage
+
+		msgToForward := payload
+
+		if proxySpecificPayload, ok := proxySpecificPayloads[proxyPeer.Node().ID()]; ok {
+			msgToForward = proxySpecificPayload
+		}
+
+		if msgToForward == nil {
+			continue
+		}
+
+		if pv.gossipCache.seen(msgToForward, proxyPeer.Node().ID()) {
+			logger.Trace("Skipping a proxy that has already seen this forward payload", "proxy", proxyPeer.Node().ID())
+			continue
+		}
+
+		fwdMessage := &istanbul.ForwardMessage{
+			Code:          ethMsgCode,
+			DestAddresses: valAddresses,
+			Msg:           msgToForward,
+		}
+		fwdMsgBytes, err := rlp.EncodeToBytes(fwdMessage)
+		if err != nil {
+			logger.Error("Failed to encode", "fwdMessage", fwdMessage)
+			return err
+		}
+
+		envelope := &pvForwardEnvelope{
+			Seqno:          atomic.AddUint64(&pv.forwardSeqno, 1),
+			ForwardMessage: fwdMsgBytes,
+		}
+		envelopeBytes, err := rlp.EncodeToBytes(envelope)
+		if err != nil {
+			logger.Error("Failed to encode", "envelope", envelope)
+			return err
+		}
+
+		// Sent over the celo_pv subprotocol, so it doesn't need its own signature:
+		// the wrapped message is already signed, and the link itself is
+		// authenticated by the PVHelloMsg handshake.
+		pv.unicast(proxyPeer, envelopeBytes, PVForwardMsg)
+	}
+
+	return nil
+}
+
+// sendValEnodesShareMsg builds a valEnodesShareData for remoteValidators' current val
+// enode table entries and unicasts it to proxyPeer, stamped with a fresh Seqno so
+// proxyPeer's seqnoTracker can tell a retransmit of the same table from a replayed,
+// stale one.
+func (pv *proxiedValidatorEngine) sendValEnodesShareMsg(proxyPeer consensus.Peer, remoteValidators []common.Address) error {
+	logger := pv.logger.New("func", "sendValEnodesShareMsg")
+
+	if proxyPeer == nil {
+		return nil
+	}
+
+	valEnodeEntries, err := pv.backend.GetValEnodeTableEntries(remoteValidators)
+	if err != nil {
+		logger.Warn("Got an error when trying to retrieve val enode table entries", "err", err)
+		return err
+	}
+
+	sharedValEnodes := make([]sharedValidatorEnode, 0, len(valEnodeEntries))
+	for address, entry := range valEnodeEntries {
+		if entry == nil || entry.Node == nil {
+			continue
+		}
+
+		sharedValEnodes = append(sharedValEnodes, sharedValidatorEnode{
+			Address:  address,
+			EnodeURL: entry.Node.String(),
+			Version:  entry.Version,
+		})
+	}
+
+	shareData := &valEnodesShareData{
+		ValEnodes: sharedValEnodes,
+		Seqno:     pv.valEnodesShareSeqnos.next(proxyPeer.Node().ID()),
+	}
+
+	payload, err := rlp.EncodeToBytes(shareData)
+	if err != nil {
+		logger.Error("Failed to encode val enodes share data", "err", err)
+		return err
+	}
+
+	logger.Trace("Sending a val enodes share msg", "proxy", proxyPeer.Node().ID(), "shareData", shareData.String())
+	pv.unicast(proxyPeer, payload, PVValEnodesShareMsg)
+
+	return nil
+}
+
+// sendShareEnodeCertificateMsg unicasts a signed enode certificate message down to
+// proxyPeer via PVShareEnodeCertificateMsg, so the proxy learns how to reach the
+// validators it forwards consensus traffic to without maintaining its own validator
+// connections. This is the send-side counterpart of the proxy's
+// handleShareEnodeCertificateMsg.
+func (pv *proxiedValidatorEngine) sendShareEnodeCertificateMsg(proxyPeer consensus.Peer, msg *istanbul.Message) error {
+	logger := pv.logger.New("func", "sendShareEnodeCertificateMsg")
+
+	if proxyPeer == nil {
+		return nil
+	}
+
+	payload, err := msg.Payload()
+	if err != nil {
+		logger.Error("Failed to get payload for enode certificate message", "err", err)
+		return err
+	}
+
+	pv.unicast(proxyPeer, payload, PVShareEnodeCertificateMsg)
+
+	return nil
+}
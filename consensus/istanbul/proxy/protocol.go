@@ -0,0 +1,323 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	// CeloPVProtocolName is the name of the internal subprotocol negotiated only on
+	// the link between a proxy and its proxied validator. It is never advertised to,
+	// or accepted from, the public celo subprotocol's peers.
+	CeloPVProtocolName = "celo_pv"
+
+	// CeloPVProtocolVersion is the current, and so far only, version of celo_pv.
+	CeloPVProtocolVersion = 1
+)
+
+// Message codes for the celo_pv subprotocol. These replace the earlier approach of
+// smuggling proxy plumbing through the public celo subprotocol's FwdMsg: a peer now
+// has to complete PVHelloMsg before any of the others are accepted.
+const (
+	PVHelloMsg uint64 = iota
+	PVShareEnodeCertificateMsg
+	PVValEnodesShareMsg
+	PVForwardMsg
+	PVDelegateSignRequestMsg
+	PVDelegateSignResponseMsg
+)
+
+// pvHelloData is exchanged by both sides immediately after the celo_pv link comes up,
+// before any consensus-related traffic. It lets each side confirm that the peer it
+// just connected to is the one configured via AddProxy, rather than an arbitrary peer
+// that guessed the internal enode.
+type pvHelloData struct {
+	NodeAddress  common.Address
+	ExternalNode *enode.Node
+}
+
+// pvForwardEnvelope wraps an RLP-encoded istanbul.ForwardMessage with a Seqno, so a
+// proxy can detect a replayed or reordered-beyond-the-grace-window forward message
+// from its proxied validator via seqnoTracker.
+type pvForwardEnvelope struct {
+	Seqno          uint64
+	ForwardMessage []byte
+}
+
+// helloTracker records which proxy peers have completed the celo_pv Hello handshake,
+// so a proxied validator (which may have several proxies connected at once) can gate
+// consensus traffic on it per-peer, rather than with a single shared flag.
+type helloTracker struct {
+	mu   sync.Mutex
+	done map[enode.ID]bool
+}
+
+func newHelloTracker() *helloTracker {
+	return &helloTracker{done: make(map[enode.ID]bool)}
+}
+
+func (h *helloTracker) markDone(peerID enode.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.done[peerID] = true
+}
+
+func (h *helloTracker) isDone(peerID enode.ID) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.done[peerID]
+}
+
+func (h *helloTracker) evictPeer(peerID enode.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.done, peerID)
+}
+
+// HandlePVMsg is the celo_pv subprotocol message handler run on a proxy. It replaces
+// dispatching ForwardMsg and DelegateSign* through the public celo subprotocol's
+// HandleMsg. Every code other than PVHelloMsg is rejected until the proxied validator
+// peer has completed the Hello handshake. If fault injection is enabled, every inbound
+// frame is also subject to faultInjector's Rx direction faults first.
+func (p *proxyEngine) HandlePVMsg(peer consensus.Peer, msgCode uint64, payload []byte) (bool, error) {
+	if p.faultInjector != nil && !p.faultInjector.ApplyRx(len(payload)) {
+		return true, nil
+	}
+
+	if msgCode == PVHelloMsg {
+		return p.handlePVHello(peer, payload)
+	}
+
+	if !p.proxiedValidatorHelloDone() {
+		p.logger.Warn("Rejecting a celo_pv message before the Hello handshake completed", "msgCode", msgCode, "from", peer.Node().ID())
+		return true, errHandshakeNotComplete
+	}
+
+	switch msgCode {
+	case PVForwardMsg:
+		return p.handleForwardMsg(peer, payload)
+	case PVShareEnodeCertificateMsg:
+		return p.handleShareEnodeCertificateMsg(peer, payload)
+	case PVValEnodesShareMsg:
+		return p.handleValEnodesShareMsg(peer, payload)
+	case PVDelegateSignResponseMsg:
+		return p.handleDelegateSignResponseMsg(peer, payload)
+	}
+
+	return false, nil
+}
+
+// HandlePVMsg is the celo_pv subprotocol message handler run on a proxied validator.
+// Every code other than PVHelloMsg is rejected until that specific proxy peer has
+// completed the Hello handshake. If fault injection is enabled, every inbound frame is
+// also subject to faultInjector's Rx direction faults first.
+func (pv *proxiedValidatorEngine) HandlePVMsg(peer consensus.Peer, msgCode uint64, payload []byte) (bool, error) {
+	if pv.faultInjector != nil && !pv.faultInjector.ApplyRx(len(payload)) {
+		return true, nil
+	}
+
+	if msgCode == PVHelloMsg {
+		return pv.handlePVHello(peer, payload)
+	}
+
+	if !pv.helloDone.isDone(peer.Node().ID()) {
+		pv.logger.Warn("Rejecting a celo_pv message before the Hello handshake completed", "msgCode", msgCode, "from", peer.Node().ID())
+		return true, errHandshakeNotComplete
+	}
+
+	switch msgCode {
+	case PVDelegateSignRequestMsg:
+		return pv.handleDelegateSignRequestMsg(peer, payload)
+	}
+
+	return false, nil
+}
+
+// proxiedValidatorHelloDone reports whether the Hello handshake has completed on this
+// proxy's single link to its proxied validator.
+func (p *proxyEngine) proxiedValidatorHelloDone() bool {
+	return atomic.LoadInt32(&p.proxiedValidatorHelloFlag) == 1
+}
+
+// handlePVHello authenticates a freshly connected proxied validator peer. The peer's
+// identity was already established by the transport when it was registered as
+// p.proxiedValidator, so this only needs to confirm it's that same peer, exactly like
+// handleForwardMsg/handleValEnodesShareMsg/handleShareEnodeCertificateMsg do -
+// pvHelloData's self-reported NodeAddress is not trusted for authentication.
+func (p *proxyEngine) handlePVHello(peer consensus.Peer, payload []byte) (bool, error) {
+	logger := p.logger.New("func", "handlePVHello")
+
+	if p.proxiedValidator == nil || p.proxiedValidator.Node().ID() != peer.Node().ID() {
+		logger.Warn("Rejecting a celo_pv Hello from a peer that is not this proxy's registered proxied validator", "from", peer.Node().ID())
+		return true, errUnauthorizedProxiedValidatorPeer
+	}
+
+	atomic.StoreInt32(&p.proxiedValidatorHelloFlag, 1)
+
+	return true, nil
+}
+
+// handlePVHello authenticates a freshly connected proxy peer against the list of
+// proxies added to this proxied validator via AddProxy, checking both the peer's
+// already-known transport identity and the external node it claims in pvHelloData
+// against that configuration, rather than trusting either alone.
+func (pv *proxiedValidatorEngine) handlePVHello(peer consensus.Peer, payload []byte) (bool, error) {
+	logger := pv.logger.New("func", "handlePVHello")
+
+	var hello pvHelloData
+	if err := rlp.DecodeBytes(payload, &hello); err != nil {
+		logger.Error("Failed to decode pvHelloData", "from", peer.Node().ID(), "err", err)
+		return true, err
+	}
+
+	_, externalNode, err := pv.ph.GetProxy(peer.Node())
+	if err != nil {
+		logger.Warn("Rejecting celo_pv connection from a peer that isn't a configured proxy", "from", peer.Node().ID())
+		return true, errUnauthorizedProxiedValidatorPeer
+	}
+
+	if hello.ExternalNode == nil || externalNode == nil || hello.ExternalNode.ID() != externalNode.ID() {
+		logger.Warn("Rejecting celo_pv Hello whose external node doesn't match this proxy's configured external node", "from", peer.Node().ID())
+		return true, errUnauthorizedProxiedValidatorPeer
+	}
+
+	pv.helloDone.markDone(peer.Node().ID())
+
+	return true, nil
+}
+
+// handleForwardMsg handles a PVForwardMsg sent by a proxy's proxied validator,
+// unwrapping the istanbul.ForwardMessage and multicasting the consensus message it
+// carries to its real destination addresses. This used to be smuggled through the
+// public celo subprotocol's FwdMsg as a "trusted peer with no signature" special
+// case; it now lives entirely behind the celo_pv handshake.
+func (p *proxyEngine) handleForwardMsg(peer consensus.Peer, payload []byte) (bool, error) {
+	logger := p.logger.New("func", "handleForwardMsg")
+
+	logger.Trace("Handling a forward message")
+
+	// Verify that it's coming from the proxied validator
+	if p.proxiedValidator == nil || p.proxiedValidator.Node().ID() != peer.Node().ID() {
+		logger.Warn("Got a forward consensus message from a peer that is not the proxy's proxied validator. Ignoring it", "from", peer.Node().ID())
+		return false, nil
+	}
+
+	var envelope pvForwardEnvelope
+	if err := rlp.DecodeBytes(payload, &envelope); err != nil {
+		logger.Error("Failed to decode a pvForwardEnvelope", "from", peer.Node().ID(), "err", err)
+		return true, err
+	}
+
+	if err := p.seqnoTracker.checkAndUpdate(peer, PVForwardMsg, envelope.Seqno); err != nil {
+		logger.Warn("Rejecting a forward message", "from", peer.Node().ID(), "seqno", envelope.Seqno, "err", err)
+		return true, err
+	}
+
+	var fwdMsg *istanbul.ForwardMessage
+	if err := rlp.DecodeBytes(envelope.ForwardMessage, &fwdMsg); err != nil {
+		logger.Error("Failed to decode a ForwardMessage", "from", peer.Node().ID(), "err", err)
+		return true, err
+	}
+
+	logger.Trace("Forward message's code", "fwdMsg.Code", fwdMsg.Code)
+
+	logger.Trace("Forwarding a message", "msg code", fwdMsg.Code)
+	if err := p.backend.Multicast(fwdMsg.DestAddresses, fwdMsg.Msg, fwdMsg.Code, false); err != nil {
+		logger.Error("Error in multicasting a forwarded message", "error", err)
+		return true, err
+	}
+
+	return true, nil
+}
+
+// UnregisterProxyPeer is the callback invoked when a proxy disconnects from this
+// proxied validator. It evicts any state that was keyed on the now-gone peer so it
+// doesn't linger until it happens to age out of an LRU on its own, and clears its
+// Hello handshake state so a reconnect has to complete it again.
+func (pv *proxiedValidatorEngine) UnregisterProxyPeer(proxyPeer consensus.Peer) {
+	pv.evictDelegateSignRequestsFromPeer(proxyPeer)
+	pv.gossipCache.evictPeer(proxyPeer.Node().ID())
+	pv.helloDone.evictPeer(proxyPeer.Node().ID())
+}
+
+// UnregisterProxiedValidatorPeer is the callback invoked when this proxy's proxied
+// validator disconnects. It clears the proxied validator peer and the Hello handshake
+// flag associated with it, so a reconnect has to complete the handshake again.
+func (p *proxyEngine) UnregisterProxiedValidatorPeer(proxiedValidatorPeer consensus.Peer) {
+	if p.proxiedValidator == nil || p.proxiedValidator.Node().ID() != proxiedValidatorPeer.Node().ID() {
+		return
+	}
+	p.proxiedValidator = nil
+	atomic.StoreInt32(&p.proxiedValidatorHelloFlag, 0)
+}
+
+// handleShareEnodeCertificateMsg handles a PVShareEnodeCertificateMsg sent by a
+// proxy's proxied validator. This now has its own message code on celo_pv, rather
+// than being special-cased out of a forwarded EnodeCertificateMsg.
+func (p *proxyEngine) handleShareEnodeCertificateMsg(peer consensus.Peer, payload []byte) (bool, error) {
+	logger := p.logger.New("func", "handleShareEnodeCertificateMsg")
+
+	if p.proxiedValidator == nil || p.proxiedValidator.Node().ID() != peer.Node().ID() {
+		logger.Warn("Got an enode certificate msg from a peer that is not the proxy's proxied validator. Ignoring it", "from", peer.Node().ID())
+		return false, nil
+	}
+
+	if err := p.handleEnodeCertificateFromFwdMsg(payload); err != nil {
+		logger.Error("Error in handling enode certificate msg", "from", peer.Node().ID(), "err", err)
+		return true, err
+	}
+
+	return true, nil
+}
+
+// handleValEnodesShareMsg handles a PVValEnodesShareMsg sent by a proxy's proxied
+// validator, updating the proxy's view of the validator enode table.
+func (p *proxyEngine) handleValEnodesShareMsg(peer consensus.Peer, payload []byte) (bool, error) {
+	logger := p.logger.New("func", "handleValEnodesShareMsg")
+
+	if p.proxiedValidator == nil || p.proxiedValidator.Node().ID() != peer.Node().ID() {
+		logger.Warn("Got a val enodes share msg from a peer that is not the proxy's proxied validator. Ignoring it", "from", peer.Node().ID())
+		return false, nil
+	}
+
+	var shareData valEnodesShareData
+	if err := rlp.DecodeBytes(payload, &shareData); err != nil {
+		logger.Error("Failed to decode a valEnodesShareData", "from", peer.Node().ID(), "err", err)
+		return true, err
+	}
+
+	if err := p.seqnoTracker.checkAndUpdate(peer, PVValEnodesShareMsg, shareData.Seqno); err != nil {
+		logger.Warn("Rejecting a val enodes share msg", "from", peer.Node().ID(), "seqno", shareData.Seqno, "err", err)
+		return true, err
+	}
+
+	logger.Trace("Updating the val enode table from a val enodes share msg", "shareData", shareData.String())
+	if err := p.backend.UpdateValEnodeTable(shareData.ValEnodes); err != nil {
+		logger.Error("Error in updating the val enode table", "err", err)
+		return true, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// seqnoGraceWindow allows a small amount of out-of-order arrival on the proxy<->proxied
+// validator link (e.g. two forward messages racing on the network) without treating a
+// slightly-lower seqno as a replay.
+const seqnoGraceWindow = 4
+
+// maxSeqnoTrackerEntries bounds seqnoTracker's LRU, one entry per (peer, message kind).
+const maxSeqnoTrackerEntries = 128
+
+// seqnoKey identifies one (peer, message kind) counter tracked by seqnoTracker. A
+// proxy tracks separate counters per message kind so a replayed ForwardMessage can't
+// be masked by legitimate valEnodesShareData traffic advancing a shared counter.
+type seqnoKey struct {
+	peerID  enode.ID
+	msgKind uint64
+}
+
+// seqnoTracker rejects replayed valEnodesShareData/ForwardMessage traffic from a peer
+// by remembering the highest sequence number seen from that peer for a given message
+// kind, and refusing anything at or below it (outside of seqnoGraceWindow). Entries
+// are not cleared when a peer disconnects, so a reconnecting peer with a lower nonce
+// is rejected rather than resetting state. They are kept in a bounded LRU, so a peer
+// whose entry has been evicted is treated as new and its next seqno is accepted.
+type seqnoTracker struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+func newSeqnoTracker() *seqnoTracker {
+	cache, _ := lru.New(maxSeqnoTrackerEntries)
+	return &seqnoTracker{cache: cache}
+}
+
+// checkAndUpdate returns errReplayedSeqno if seqno looks like a replay of an older
+// message from peer, and otherwise records seqno as the new high-water mark for
+// (peer, msgKind). The first message seen from a peer always seeds its counter.
+func (t *seqnoTracker) checkAndUpdate(peer consensus.Peer, msgKind uint64, seqno uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := seqnoKey{peerID: peer.Node().ID(), msgKind: msgKind}
+
+	if last, ok := t.cache.Get(key); ok {
+		lastSeqno := last.(uint64)
+		if seqno <= lastSeqno {
+			if seqno < lastSeqno && seqno+seqnoGraceWindow > lastSeqno {
+				// Within the grace window for out-of-order arrival: accept, but
+				// don't move the high-water mark backwards.
+				return nil
+			}
+			return errReplayedSeqno
+		}
+	}
+
+	t.cache.Add(key, seqno)
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"testing"
+)
+
+func TestSeqnoTrackerAcceptsFirstAndIncreasingSeqnos(t *testing.T) {
+	tracker := newSeqnoTracker()
+	peer := newTestPeer(t)
+
+	if err := tracker.checkAndUpdate(peer, PVForwardMsg, 1); err != nil {
+		t.Fatalf("first seqno from a peer should always be accepted, got %v", err)
+	}
+	if err := tracker.checkAndUpdate(peer, PVForwardMsg, 2); err != nil {
+		t.Fatalf("strictly increasing seqno should be accepted, got %v", err)
+	}
+}
+
+func TestSeqnoTrackerRejectsReplayBeyondGraceWindow(t *testing.T) {
+	tracker := newSeqnoTracker()
+	peer := newTestPeer(t)
+
+	if err := tracker.checkAndUpdate(peer, PVForwardMsg, 10); err != nil {
+		t.Fatalf("seeding seqno should be accepted, got %v", err)
+	}
+
+	err := tracker.checkAndUpdate(peer, PVForwardMsg, 10-seqnoGraceWindow-1)
+	if err != errReplayedSeqno {
+		t.Fatalf("got %v, want errReplayedSeqno for a seqno well below the high-water mark", err)
+	}
+}
+
+func TestSeqnoTrackerAllowsOutOfOrderWithinGraceWindow(t *testing.T) {
+	tracker := newSeqnoTracker()
+	peer := newTestPeer(t)
+
+	if err := tracker.checkAndUpdate(peer, PVForwardMsg, 10); err != nil {
+		t.Fatalf("seeding seqno should be accepted, got %v", err)
+	}
+
+	// 10 - seqnoGraceWindow + 1 is inside the grace window: a slightly reordered
+	// frame, not a replay.
+	if err := tracker.checkAndUpdate(peer, PVForwardMsg, 10-seqnoGraceWindow+1); err != nil {
+		t.Fatalf("a seqno within the grace window should be accepted, got %v", err)
+	}
+
+	// The grace window must not move the high-water mark backwards: a genuine
+	// replay of the original high seqno is still a replay, not a fresh message.
+	if err := tracker.checkAndUpdate(peer, PVForwardMsg, 10); err != errReplayedSeqno {
+		t.Fatalf("got %v, want errReplayedSeqno - the high-water mark should still be 10", err)
+	}
+}
+
+func TestSeqnoTrackerTracksMessageKindsIndependently(t *testing.T) {
+	tracker := newSeqnoTracker()
+	peer := newTestPeer(t)
+
+	if err := tracker.checkAndUpdate(peer, PVForwardMsg, 100); err != nil {
+		t.Fatalf("seeding PVForwardMsg seqno should be accepted, got %v", err)
+	}
+
+	// A low seqno on a different message kind from the same peer must not be
+	// treated as a replay of PVForwardMsg's counter.
+	if err := tracker.checkAndUpdate(peer, PVValEnodesShareMsg, 1); err != nil {
+		t.Fatalf("a different message kind should track its own counter, got %v", err)
+	}
+}
+
+func TestSeqnoTrackerTracksPeersIndependently(t *testing.T) {
+	tracker := newSeqnoTracker()
+	peerA := newTestPeer(t)
+	peerB := newTestPeer(t)
+
+	if err := tracker.checkAndUpdate(peerA, PVForwardMsg, 100); err != nil {
+		t.Fatalf("seeding peerA's seqno should be accepted, got %v", err)
+	}
+	if err := tracker.checkAndUpdate(peerB, PVForwardMsg, 1); err != nil {
+		t.Fatalf("peerB's counter must be independent of peerA's, got %v", err)
+	}
+}
+
+func TestSeqnoTrackerLRUEvictsLeastRecentlyUsedPeer(t *testing.T) {
+	tracker := newSeqnoTracker()
+
+	evictedPeer := newTestPeer(t)
+	if err := tracker.checkAndUpdate(evictedPeer, PVForwardMsg, 100); err != nil {
+		t.Fatalf("seeding evictedPeer's seqno should be accepted, got %v", err)
+	}
+
+	// Touch maxSeqnoTrackerEntries more (peer, msgKind) keys so evictedPeer's entry,
+	// never touched again, ages out of the bounded LRU.
+	for i := 0; i < maxSeqnoTrackerEntries; i++ {
+		peer := newTestPeer(t)
+		if err := tracker.checkAndUpdate(peer, PVForwardMsg, 1); err != nil {
+			t.Fatalf("seeding filler peer %d should be accepted, got %v", i, err)
+		}
+	}
+
+	// evictedPeer's high-water mark is gone, so a reconnect replaying an old, low
+	// seqno is indistinguishable from a first-ever message and is accepted - the
+	// bound on memory trades away replay protection for peers idle long enough to
+	// be evicted.
+	if err := tracker.checkAndUpdate(evictedPeer, PVForwardMsg, 1); err != nil {
+		t.Fatalf("got %v, want nil - evictedPeer's old high-water mark should have been evicted", err)
+	}
+}
@@ -52,8 +52,35 @@ var (
 
 	// ErrNodeNotProxy is returned if this node is not a proxy
 	ErrNodeNotProxy = errors.New("node not a proxy")
+
+	// errDelegateSignTimeout is returned when a delegate sign request does not get a
+	// response from the other side of the proxy<->proxied validator link before the
+	// deadline elapses.
+	errDelegateSignTimeout = errors.New("timed out waiting for delegate sign response")
+
+	// ErrNoStatsProxy is returned when a delegate sign response (e.g. for an ethstats
+	// message) is received for a RequestID that has no outstanding request context,
+	// either because it already timed out or because no proxy ever asked for it.
+	ErrNoStatsProxy = errors.New("no proxy associated with this delegate sign request")
+
+	// errReplayedSeqno is returned when a valEnodesShareData or forward message's
+	// Seqno is at or below the last one accepted from that peer, i.e. it looks like a
+	// replay of an older message rather than new state.
+	errReplayedSeqno = errors.New("message seqno is not greater than the last one seen from this peer")
+
+	// errFaultInjectorNotEnabled is returned by PrivateFaultInjectorAPI when the node
+	// wasn't started with fault injection enabled on the proxy<->proxied validator link.
+	errFaultInjectorNotEnabled = errors.New("fault injection is not enabled on this node")
+
+	// errHandshakeNotComplete is returned when a celo_pv message other than PVHelloMsg
+	// arrives on a connection that hasn't completed the Hello handshake yet.
+	errHandshakeNotComplete = errors.New("celo_pv Hello handshake has not completed on this connection")
 )
 
+// delegateSignTimeout is how long a delegate sign request will wait for a response
+// before giving up.
+const delegateSignTimeout = 5 * time.Second
+
 type ProxyEngine interface {
 	// HandleMsg is the `celo` subprotocol message handler for proxies.
 	HandleMsg(peer consensus.Peer, msgCode uint64, payload []byte) (bool, error)
@@ -68,7 +95,10 @@ type ProxyEngine interface {
 	// will remove the proxied validator's peer from the proxy's state.
 	UnregisterProxiedValidatorPeer(proxiedValidatorPeer consensus.Peer)
 
-	// SendDelegateSignMsgToProxiedValidator(msg []byte) error
+	// SendDelegateSignMsgToProxiedValidator will send a delegate sign request for the
+	// given payload to the proxy's proxied validator, and block until either a signed
+	// response is received or the request times out.
+	SendDelegateSignMsgToProxiedValidator(msg []byte) error
 
 	// SendEnodeCertificateMsgToProxiedValidator will send the given enode certificate
 	// message to the proxied validator.
@@ -100,17 +130,24 @@ type ProxiedValidatorEngine interface {
 
 	// UnregisterProxyPeer is the callback function that should be called
 	// when a proxy is disconnected from a proxied validator.  This function will
-	// notify the proxy handler that a proxy has disconnected.
+	// notify the proxy handler that a proxy has disconnected, and evict any
+	// outstanding delegate sign request origins pointing at it.
 	UnregisterProxyPeer(proxyPeer consensus.Peer)
 
 	// sendValEnodesShareMsg will send a val enode share messages with the val enode table entries associated
 	// with the remoteValidators to the proxyPeer.
 	sendValEnodesShareMsg(proxyPeer consensus.Peer, remoteValidators []common.Address) error
 
+	// sendShareEnodeCertificateMsg will send the given enode certificate message to the
+	// proxyPeer.
+	sendShareEnodeCertificateMsg(proxyPeer consensus.Peer, msg *istanbul.Message) error
+
 	// SendForwardMsg will send a forward message.
 	SendForwardMsg(proxyPeers []consensus.Peer, finalDestAddresses []common.Address, ethMsgCode uint64, payload []byte, proxySpecificPayload map[enode.ID][]byte) error
 
-	// SendDelegateSignMsgToProxy(msg []byte) error
+	// SendDelegateSignMsgToProxy will send the given RLP-encoded delegate sign response
+	// to the proxy that sent the matching request. It does not wait for a reply.
+	SendDelegateSignMsgToProxy(msg []byte) error
 
 	// SendValEnodeShareMsgToAllProxies will send the appropriate val enode share message to each
 	// connected proxy.
@@ -192,6 +229,13 @@ type sharedValidatorEnode struct {
 
 type valEnodesShareData struct {
 	ValEnodes []sharedValidatorEnode
+
+	// Seqno is a monotonically increasing sequence number set by the proxied
+	// validator, one counter per proxy it's connected to. A proxy rejects any
+	// valEnodesShareData whose Seqno isn't strictly greater (outside of
+	// seqnoGraceWindow) than the last one it accepted from that proxied validator,
+	// so an on-path adversary can't replay a stale val enode table.
+	Seqno uint64
 }
 
 func (sve *sharedValidatorEnode) String() string {
@@ -212,18 +256,20 @@ func (sd *valEnodesShareData) String() string {
 
 // EncodeRLP serializes sd into the Ethereum RLP format.
 func (sd *valEnodesShareData) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, []interface{}{sd.ValEnodes})
+	return rlp.Encode(w, []interface{}{sd.ValEnodes, sd.Seqno})
 }
 
 // DecodeRLP implements rlp.Decoder, and load the sd fields from a RLP stream.
 func (sd *valEnodesShareData) DecodeRLP(s *rlp.Stream) error {
 	var msg struct {
 		ValEnodes []sharedValidatorEnode
+		Seqno     uint64
 	}
 
 	if err := s.Decode(&msg); err != nil {
 		return err
 	}
 	sd.ValEnodes = msg.ValEnodes
+	sd.Seqno = msg.Seqno
 	return nil
 }